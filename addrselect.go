@@ -0,0 +1,196 @@
+package selector
+
+import (
+	"net"
+	"sort"
+	"strconv"
+)
+
+// defaultProbePort is used for the UDP-connect reachability trick when a
+// hostPool's configured port can't be parsed as a number (e.g. a named
+// service port); any open-ish port works since no packet is ever sent.
+const defaultProbePort = 11211
+
+// PolicyEntry is one row of an RFC 6724 destination-address-selection
+// policy table: addresses in Prefix get Label and Precedence.
+type PolicyEntry struct {
+	Prefix     *net.IPNet
+	Label      int
+	Precedence int
+}
+
+// PolicyTable is an ordered RFC 6724 policy table. Longest matching
+// prefix wins, same as the RFC's reference table.
+type PolicyTable []PolicyEntry
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// DefaultPolicyTable returns RFC 6724's default policy table (section
+// 2.1). Callers can pass a customized table via WithAddressPolicy.
+func DefaultPolicyTable() PolicyTable {
+	return PolicyTable{
+		{Prefix: mustCIDR("::1/128"), Label: 0, Precedence: 50},
+		{Prefix: mustCIDR("::/0"), Label: 1, Precedence: 40},
+		{Prefix: mustCIDR("2002::/16"), Label: 2, Precedence: 30},
+		{Prefix: mustCIDR("::ffff:0:0/96"), Label: 4, Precedence: 35},
+		{Prefix: mustCIDR("fec0::/10"), Label: 11, Precedence: 1},
+		{Prefix: mustCIDR("3ffe::/16"), Label: 12, Precedence: 1},
+		{Prefix: mustCIDR("fc00::/7"), Label: 13, Precedence: 3},
+	}
+}
+
+// classify returns the label and precedence of the longest prefix in t
+// matching ip, falling back to the ::/0 default (label 1, precedence 40)
+// if nothing matches.
+func (t PolicyTable) classify(ip net.IP) (label, precedence int) {
+	ip16 := ip.To16()
+	bestOnes := -1
+	label, precedence = 1, 40
+	for _, e := range t {
+		if !e.Prefix.Contains(ip16) {
+			continue
+		}
+		ones, _ := e.Prefix.Mask.Size()
+		if ones > bestOnes {
+			bestOnes = ones
+			label, precedence = e.Label, e.Precedence
+		}
+	}
+	return label, precedence
+}
+
+const (
+	scopeLinkLocal = 2
+	scopeGlobal    = 14
+)
+
+// scopeOf returns the RFC 6724 scope of ip: link-local for loopback and
+// link-local unicast/multicast addresses, global otherwise.
+func scopeOf(ip net.IP) int {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return scopeLinkLocal
+	}
+	return scopeGlobal
+}
+
+// reachabilityProbe is the hook rankAddresses uses to decide whether an
+// address's family is actually reachable. It's a package var, not a
+// direct call to preferredSource, so tests can fake reachability instead
+// of depending on the sandbox's own kernel routing table (preferredSource
+// only detects "is there a route", which varies across environments).
+var reachabilityProbe = preferredSource
+
+// preferredSource asks the kernel which local address it would use to
+// reach dst, via the standard UDP-connect trick: connecting a UDP socket
+// never sends a packet, but the kernel still picks (and lets us read
+// back) the route it would take. An error means dst's address family
+// isn't actually reachable.
+func preferredSource(dst net.IP, port int) (net.IP, bool) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dst, Port: port})
+	if err != nil {
+		return nil, false
+	}
+	defer conn.Close()
+
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, false
+	}
+	return local.IP, true
+}
+
+// commonPrefixLen returns the number of leading bits a and b share.
+func commonPrefixLen(a, b net.IP) int {
+	a16, b16 := a.To16(), b.To16()
+	if a16 == nil || b16 == nil {
+		return 0
+	}
+	n := 0
+	for i := range a16 {
+		x := a16[i] ^ b16[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		return n
+	}
+	return n
+}
+
+// addrCandidate is one destination address plus everything the RFC 6724
+// rule chain needs to compare it against the others.
+type addrCandidate struct {
+	ip     net.IP
+	usable bool
+
+	srcScope, dstScope int
+	srcLabel, dstLabel int
+	precedence         int
+	commonLen          int
+}
+
+// rankAddresses orders ips per the RFC 6724 rule chain (drop unusable,
+// prefer matching scope, matching label, higher precedence, longer
+// common prefix) and drops any address whose family isn't actually
+// reachable, per preferredSource. portStr is the hostPool's configured
+// port, used only for the UDP-connect probe.
+func rankAddresses(ips []net.IP, policy PolicyTable, portStr string) []net.IP {
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		port = defaultProbePort
+	}
+
+	candidates := make([]addrCandidate, len(ips))
+	for i, ip := range ips {
+		c := addrCandidate{ip: ip, dstScope: scopeOf(ip)}
+		c.dstLabel, c.precedence = policy.classify(ip)
+		if src, ok := reachabilityProbe(ip, port); ok {
+			c.usable = true
+			c.srcScope = scopeOf(src)
+			c.srcLabel, _ = policy.classify(src)
+			c.commonLen = commonPrefixLen(src, ip)
+		}
+		candidates[i] = c
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.usable != b.usable {
+			return a.usable
+		}
+		if !a.usable {
+			return false
+		}
+		if am, bm := a.dstScope == a.srcScope, b.dstScope == b.srcScope; am != bm {
+			return am
+		}
+		if am, bm := a.dstLabel == a.srcLabel, b.dstLabel == b.srcLabel; am != bm {
+			return am
+		}
+		if a.precedence != b.precedence {
+			return a.precedence > b.precedence
+		}
+		if a.commonLen != b.commonLen {
+			return a.commonLen > b.commonLen
+		}
+		return false
+	})
+
+	out := make([]net.IP, 0, len(candidates))
+	for _, c := range candidates {
+		if c.usable {
+			out = append(out, c.ip)
+		}
+	}
+	return out
+}
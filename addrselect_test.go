@@ -0,0 +1,53 @@
+package selector
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRankAddressesDropsUnreachableFamily checks the motivating RFC 6724
+// scenario: a hostname with both a reachable IPv4 record and a global
+// IPv6 record should rank down to just the reachable address, not
+// round-robin onto the broken family. Real route tables vary across
+// environments (some sandboxes have a default IPv6 route, some don't),
+// so reachabilityProbe is faked here rather than relying on the actual
+// kernel routing table preferredSource would consult.
+func TestRankAddressesDropsUnreachableFamily(t *testing.T) {
+	reachable := net.ParseIP("127.0.0.1")
+	unreachable := net.ParseIP("2001:4860:4860::8888") // global v6, pretend no route
+
+	defer stubReachabilityProbe(map[string]bool{
+		reachable.String():   true,
+		unreachable.String(): false,
+	})()
+
+	ranked := rankAddresses([]net.IP{unreachable, reachable}, DefaultPolicyTable(), "11211")
+
+	assert.Equal(t, []net.IP{reachable}, ranked)
+}
+
+// stubReachabilityProbe replaces reachabilityProbe with a fake that
+// reports dst as reachable (using dst itself as the "preferred source",
+// which is all rankAddresses' callers need) iff up[dst.String()] is
+// true, and returns a func to restore the real probe.
+func stubReachabilityProbe(up map[string]bool) func() {
+	orig := reachabilityProbe
+	reachabilityProbe = func(dst net.IP, port int) (net.IP, bool) {
+		return dst, up[dst.String()]
+	}
+	return func() { reachabilityProbe = orig }
+}
+
+// TestRankAddressesPrefersLoopback checks that among two reachable
+// addresses, the one RFC 6724 prefers by precedence/label/scope sorts
+// first rather than leaving the DNS answer order untouched.
+func TestRankAddressesPrefersLoopback(t *testing.T) {
+	v4 := net.ParseIP("127.0.0.1")
+	v6Loopback := net.ParseIP("::1")
+
+	ranked := rankAddresses([]net.IP{v4, v6Loopback}, DefaultPolicyTable(), "11211")
+
+	assert.Equal(t, []net.IP{v6Loopback, v4}, ranked)
+}
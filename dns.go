@@ -0,0 +1,192 @@
+package selector
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultResolveInterval is how often watchDNS re-resolves hostname-form
+// servers when no interval is configured via WithResolveInterval.
+const defaultResolveInterval = 30 * time.Second
+
+// resolveTimeout bounds a single LookupIPAddr call so a stuck resolver
+// can't wedge the background goroutine forever.
+const resolveTimeout = 5 * time.Second
+
+// Resolver looks up the current IP addresses behind a hostname.
+// *net.Resolver (net.DefaultResolver) satisfies this directly, and it's
+// the default; tests or callers with their own DNS client can inject
+// another implementation instead of patching the global resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Option configures a ServerList at construction time.
+type Option func(*ServerList)
+
+// WithResolver overrides the Resolver used to track hostname-form
+// servers. Defaults to net.DefaultResolver.
+func WithResolver(r Resolver) Option {
+	return func(s *ServerList) { s.resolver = r }
+}
+
+// WithResolveInterval overrides how often hostname-form servers are
+// re-resolved in the background. Defaults to defaultResolveInterval.
+func WithResolveInterval(d time.Duration) Option {
+	return func(s *ServerList) { s.resolveInterval = d }
+}
+
+// WithAddressPolicy overrides the RFC 6724 policy table used to rank and
+// filter a hostname's resolved addresses. Defaults to DefaultPolicyTable.
+func WithAddressPolicy(t PolicyTable) Option {
+	return func(s *ServerList) { s.addrPolicy = t }
+}
+
+// hostPool tracks the live set of IPs a single hostname-form configured
+// server currently resolves to.
+type hostPool struct {
+	host string
+	port string
+
+	mu  sync.Mutex
+	ips []net.IP
+}
+
+// snapshot returns a copy of the pool's current IPs.
+func (h *hostPool) snapshot() []net.IP {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]net.IP(nil), h.ips...)
+}
+
+// addrs returns a net.Addr, "ip:port", for each IP currently in the pool.
+func (h *hostPool) addrs() []net.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]net.Addr, len(h.ips))
+	for i, ip := range h.ips {
+		out[i] = newAddrFromString("tcp", net.JoinHostPort(ip.String(), h.port))
+	}
+	return out
+}
+
+func (h *hostPool) set(ips []net.IP) {
+	h.mu.Lock()
+	h.ips = ips
+	h.mu.Unlock()
+}
+
+// hostnameOf reports whether server is a tcp "host:port" address whose
+// host is a DNS name rather than an IP literal or a unix socket path.
+func hostnameOf(server string) (string, bool) {
+	if strings.Contains(server, "/") {
+		return "", false
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) != nil {
+		return "", false
+	}
+	return host, true
+}
+
+// resolveHost queries s.resolver for hp.host and stores the result,
+// ranked and filtered per RFC 6724 (see addrselect.go) so a hostname
+// that resolves to both an unreachable and a reachable address family
+// doesn't round-robin onto the broken one.
+func (s *ServerList) resolveHost(hp *hostPool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	ipAddrs, err := s.resolver.LookupIPAddr(ctx, hp.host)
+	if err != nil {
+		return err
+	}
+
+	ips := make([]net.IP, len(ipAddrs))
+	for i, a := range ipAddrs {
+		ips[i] = a.IP
+	}
+	hp.set(rankAddresses(ips, s.addrPolicy, hp.port))
+	return nil
+}
+
+// watchDNS periodically re-resolves every tracked hostname and rebuilds
+// the ring when any of their pools changed, until Close is called.
+func (s *ServerList) watchDNS() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.resolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.refreshHosts()
+		}
+	}
+}
+
+func (s *ServerList) refreshHosts() {
+	s.mu.RLock()
+	hosts := s.hosts
+	s.mu.RUnlock()
+
+	changed := false
+	for _, hp := range hosts {
+		old := hp.snapshot()
+		if err := s.resolveHost(hp); err != nil {
+			s.logger.Warn("dns refresh failed", "host", hp.host, "error", err)
+			continue
+		}
+
+		current := hp.snapshot()
+		if sameIPs(old, current) {
+			continue
+		}
+
+		changed = true
+		s.logger.Info("dns pool changed", "host", hp.host, "old", old, "new", current)
+		if s.observer != nil {
+			s.observer.OnResolve(hp.host, old, current)
+		}
+	}
+
+	if changed {
+		s.mu.Lock()
+		points := s.rebuildRingLocked()
+		s.mu.Unlock()
+
+		s.notifyRingRebuild(points)
+	}
+}
+
+// sameIPs reports whether a and b contain the same IPs, ignoring order.
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, ip := range a {
+		seen[ip.String()]++
+	}
+	for _, ip := range b {
+		if seen[ip.String()] == 0 {
+			return false
+		}
+		seen[ip.String()]--
+	}
+	return true
+}
+
+// Close stops the background DNS-refresh goroutine. Safe to call more
+// than once; a ServerList with no hostname-form servers still needs
+// Close to release its goroutine.
+func (s *ServerList) Close() {
+	s.closeOnce.Do(func() { close(s.closeCh) })
+	s.wg.Wait()
+}
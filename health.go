@@ -0,0 +1,247 @@
+package selector
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval   = 5 * time.Second
+	defaultFailureThreshold      = 3
+	defaultRecoveryProbeInterval = 10 * time.Second
+	defaultHealthCheckWorkers    = 8
+	defaultProbeTimeout          = 2 * time.Second
+)
+
+// HealthCheckConfig configures the optional active health checker
+// enabled via WithHealthChecking. Zero fields fall back to their
+// default* constants.
+type HealthCheckConfig struct {
+	// Interval between probes of a healthy server.
+	Interval time.Duration
+	// FailureThreshold is the number of consecutive failed probes
+	// before a server is marked down.
+	FailureThreshold int
+	// RecoveryProbeInterval is how often a down server is re-probed to
+	// check whether it has recovered.
+	RecoveryProbeInterval time.Duration
+	// Workers bounds how many probes run concurrently.
+	Workers int
+	// ProbeTimeout bounds a single probe's dial, write and read.
+	ProbeTimeout time.Duration
+}
+
+func (c HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if c.Interval <= 0 {
+		c.Interval = defaultHealthCheckInterval
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaultFailureThreshold
+	}
+	if c.RecoveryProbeInterval <= 0 {
+		c.RecoveryProbeInterval = defaultRecoveryProbeInterval
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaultHealthCheckWorkers
+	}
+	if c.ProbeTimeout <= 0 {
+		c.ProbeTimeout = defaultProbeTimeout
+	}
+	return c
+}
+
+// WithHealthChecking enables active health checking: every known server
+// is periodically probed (tcp servers with a memcached "version\r\n"
+// command, unix socket servers with a plain connect), and
+// PickServer skips a server that has failed FailureThreshold
+// consecutive probes in favor of the next live point on the ring.
+func WithHealthChecking(cfg HealthCheckConfig) Option {
+	return func(s *ServerList) {
+		s.healthCfg = cfg.withDefaults()
+		s.healthEnabled = true
+	}
+}
+
+// serverHealth is the health checker's view of a single server.
+type serverHealth struct {
+	mu          sync.Mutex
+	healthy     bool
+	consecFails int
+	lastRTT     time.Duration
+	lastProbeAt time.Time
+}
+
+func (h *serverHealth) snapshot() (healthy bool, lastRTT time.Duration, consecFails int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy, h.lastRTT, h.consecFails
+}
+
+func (h *serverHealth) dueForProbe(now time.Time, recoveryInterval time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.healthy {
+		return true
+	}
+	return now.Sub(h.lastProbeAt) >= recoveryInterval
+}
+
+// recordResult applies a probe outcome and reports whether the server's
+// healthy/down state changed as a result.
+func (h *serverHealth) recordResult(now time.Time, ok bool, rtt time.Duration, failureThreshold int) (changed, nowHealthy bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	was := h.healthy
+	h.lastProbeAt = now
+	if ok {
+		h.consecFails = 0
+		h.lastRTT = rtt
+		h.healthy = true
+	} else {
+		h.consecFails++
+		if h.consecFails >= failureThreshold {
+			h.healthy = false
+		}
+	}
+	return was != h.healthy, h.healthy
+}
+
+// healthFor returns (creating if needed) the serverHealth entry for addr.
+func (s *ServerList) healthFor(addr net.Addr) *serverHealth {
+	key := addr.String()
+
+	s.healthMu.RLock()
+	h, ok := s.health[key]
+	s.healthMu.RUnlock()
+	if ok {
+		return h
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if h, ok := s.health[key]; ok {
+		return h
+	}
+	h = &serverHealth{healthy: true}
+	s.health[key] = h
+	return h
+}
+
+// isHealthyLocked reports whether addr is currently considered live.
+// A server with no recorded health (not yet probed) is treated as
+// healthy. Callers must hold s.mu (for reading or writing).
+func (s *ServerList) isHealthyLocked(addr net.Addr) bool {
+	s.healthMu.RLock()
+	h, ok := s.health[addr.String()]
+	s.healthMu.RUnlock()
+	if !ok {
+		return true
+	}
+	healthy, _, _ := h.snapshot()
+	return healthy
+}
+
+// ServerStatus returns the health checker's current view of addr: is it
+// live, what was its last successful probe latency, and its current
+// consecutive-failure count. A server not yet probed (including when
+// health checking is disabled) reports (true, 0, 0).
+func (s *ServerList) ServerStatus(addr net.Addr) (healthy bool, lastRTT time.Duration, consecFails int) {
+	s.healthMu.RLock()
+	h, ok := s.health[addr.String()]
+	s.healthMu.RUnlock()
+	if !ok {
+		return true, 0, 0
+	}
+	return h.snapshot()
+}
+
+// watchHealth probes every known server on an interval until Close is
+// called, using a bounded worker pool so a burst of slow or dead
+// servers can't spawn unbounded goroutines.
+func (s *ServerList) watchHealth() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.healthCfg.Interval)
+	defer ticker.Stop()
+
+	sem := make(chan struct{}, s.healthCfg.Workers)
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case <-ticker.C:
+			s.probeAll(sem)
+		}
+	}
+}
+
+func (s *ServerList) probeAll(sem chan struct{}) {
+	s.mu.RLock()
+	addrs := s.allAddrsLocked()
+	s.mu.RUnlock()
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		addr := addr
+		h := s.healthFor(addr)
+		if !h.dueForProbe(now, s.healthCfg.RecoveryProbeInterval) {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.probeOne(addr, h)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *ServerList) probeOne(addr net.Addr, h *serverHealth) {
+	start := time.Now()
+	ok := probe(addr, s.healthCfg.ProbeTimeout)
+	rtt := time.Since(start)
+
+	changed, nowHealthy := h.recordResult(start, ok, rtt, s.healthCfg.FailureThreshold)
+	if changed {
+		s.logger.Info("server health changed", "addr", addr.String(), "healthy", nowHealthy, "rtt", rtt)
+		if s.observer != nil {
+			s.observer.OnHealthChange(addr, nowHealthy, rtt)
+		}
+	}
+}
+
+// probe dials addr and, for tcp servers, round-trips the memcached
+// "version\r\n" command the fake servers in selector_test.go already
+// answer. A unix socket probe only needs the connect to succeed.
+func probe(addr net.Addr, timeout time.Duration) bool {
+	conn, err := net.DialTimeout(addr.Network(), addr.String(), timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	if addr.Network() == "unix" {
+		return true
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+	if _, err := conn.Write([]byte("version\r\n")); err != nil {
+		return false
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(line, "VERSION")
+}
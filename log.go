@@ -0,0 +1,36 @@
+package selector
+
+import "go.uber.org/zap"
+
+// Logger is the minimal structured-logging interface ServerList needs.
+// kv are alternating key/value pairs, same convention as zap's
+// SugaredLogger.*w methods, so embedding apps can adapt whatever logger
+// they already use instead of taking a hard dependency on zap.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// NewZapLogger adapts a *zap.Logger to Logger. This is the default
+// Logger used by NewServerList when none is given.
+func NewZapLogger(l *zap.Logger) Logger {
+	return &zapLogger{l: l.Sugar()}
+}
+
+type zapLogger struct {
+	l *zap.SugaredLogger
+}
+
+func (z *zapLogger) Debug(msg string, kv ...interface{}) { z.l.Debugw(msg, kv...) }
+func (z *zapLogger) Info(msg string, kv ...interface{})  { z.l.Infow(msg, kv...) }
+func (z *zapLogger) Warn(msg string, kv ...interface{})  { z.l.Warnw(msg, kv...) }
+func (z *zapLogger) Error(msg string, kv ...interface{}) { z.l.Errorw(msg, kv...) }
+
+// defaultLogger returns the Logger NewServerList falls back to when
+// called with a nil logger: a zap.NewNop() adapter, so embedding apps
+// that don't care about these logs aren't forced to wire one up.
+func defaultLogger() Logger {
+	return NewZapLogger(zap.NewNop())
+}
@@ -0,0 +1,52 @@
+package selector
+
+import (
+	"net"
+	"time"
+)
+
+// Observer receives events from a ServerList for metrics or tracing. All
+// methods are optional in spirit: embed NoopObserver in a struct and
+// override only the methods you care about, or wire a ready-made
+// implementation such as the Prometheus adapter in the prometheus
+// subpackage. A nil Observer (the default) means no events are emitted.
+//
+// Do not embed the Observer interface itself to get optional methods -
+// that leaves the field a nil interface, and calling an unoverridden
+// method panics. NoopObserver is a concrete value with no-op methods for
+// exactly this purpose.
+type Observer interface {
+	// OnPick is called after PickServer successfully resolves key to addr.
+	OnPick(key string, addr net.Addr)
+	// OnResolve is called when a tracked hostname's resolved IP pool
+	// changes, with the previous and new pool.
+	OnResolve(host string, old, new []net.IP)
+	// OnHealthChange is called when a server's up/down status flips.
+	OnHealthChange(addr net.Addr, up bool, rtt time.Duration)
+	// OnRingRebuild is called whenever the hash ring is recomputed, with
+	// the resulting number of ring points.
+	OnRingRebuild(points int)
+}
+
+// NoopObserver implements Observer with no-op methods. Embed it by value
+// in a struct to pick up safe defaults for every method you don't
+// override, e.g.:
+//
+//	type pickLogger struct {
+//		selector.NoopObserver
+//	}
+//
+//	func (pickLogger) OnPick(key string, addr net.Addr) { log.Println(key, addr) }
+type NoopObserver struct{}
+
+func (NoopObserver) OnPick(key string, addr net.Addr)                         {}
+func (NoopObserver) OnResolve(host string, old, new []net.IP)                 {}
+func (NoopObserver) OnHealthChange(addr net.Addr, up bool, rtt time.Duration) {}
+func (NoopObserver) OnRingRebuild(points int)                                 {}
+
+var _ Observer = NoopObserver{}
+
+// WithObserver registers o to receive ServerList events.
+func WithObserver(o Observer) Option {
+	return func(s *ServerList) { s.observer = o }
+}
@@ -0,0 +1,127 @@
+// Package prometheus is a ready-made selector.Observer that exports
+// ServerList events as Prometheus metrics, so callers don't have to hand
+// write the wiring between selector events and their metrics stack.
+package prometheus
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	selector "github.com/simutin32/gomemcache-server-selector"
+)
+
+// Observer implements selector.Observer, exporting:
+//
+//   - picks_total{addr}                 (counter)
+//   - resolve_total{host,result}        (counter; result is always
+//     "changed", since OnResolve only fires when a hostname's pool
+//     actually changes)
+//   - servers_up                        (gauge)
+//   - ring_points                       (gauge)
+//   - health_check_rtt_seconds          (histogram)
+//   - resolve_duration_seconds          (histogram of time between
+//     observed DNS changes per host - OnResolve carries no call-latency
+//     information to measure LookupIPAddr itself)
+type Observer struct {
+	picksTotal   *prometheus.CounterVec
+	resolveTotal *prometheus.CounterVec
+	serversUp    prometheus.Gauge
+	ringPoints   prometheus.Gauge
+	healthRTT    prometheus.Histogram
+	resolveSecs  *prometheus.HistogramVec
+
+	mu          sync.Mutex
+	up          map[string]bool
+	lastResolve map[string]time.Time
+}
+
+var _ selector.Observer = (*Observer)(nil)
+
+// New builds an Observer and registers its collectors with reg.
+func New(reg prometheus.Registerer) (*Observer, error) {
+	o := &Observer{
+		picksTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "picks_total",
+			Help: "Number of PickServer calls that returned addr.",
+		}, []string{"addr"}),
+		resolveTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "resolve_total",
+			Help: "Number of times a tracked hostname's resolved IP pool changed.",
+		}, []string{"host", "result"}),
+		serversUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "servers_up",
+			Help: "Number of servers the health checker currently considers live.",
+		}),
+		ringPoints: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ring_points",
+			Help: "Number of points currently on the consistent hash ring.",
+		}),
+		healthRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "health_check_rtt_seconds",
+			Help:    "Round-trip latency of health check probes.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		resolveSecs: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "resolve_duration_seconds",
+			Help:    "Time between observed DNS pool changes, per host.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"host"}),
+		up:          make(map[string]bool),
+		lastResolve: make(map[string]time.Time),
+	}
+
+	for _, c := range []prometheus.Collector{
+		o.picksTotal, o.resolveTotal, o.serversUp, o.ringPoints, o.healthRTT, o.resolveSecs,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// OnPick implements selector.Observer.
+func (o *Observer) OnPick(_ string, addr net.Addr) {
+	o.picksTotal.WithLabelValues(addr.String()).Inc()
+}
+
+// OnResolve implements selector.Observer.
+func (o *Observer) OnResolve(host string, _, _ []net.IP) {
+	o.resolveTotal.WithLabelValues(host, "changed").Inc()
+
+	o.mu.Lock()
+	last, ok := o.lastResolve[host]
+	now := time.Now()
+	o.lastResolve[host] = now
+	o.mu.Unlock()
+
+	if ok {
+		o.resolveSecs.WithLabelValues(host).Observe(now.Sub(last).Seconds())
+	}
+}
+
+// OnHealthChange implements selector.Observer.
+func (o *Observer) OnHealthChange(addr net.Addr, up bool, rtt time.Duration) {
+	o.healthRTT.Observe(rtt.Seconds())
+
+	key := addr.String()
+	o.mu.Lock()
+	wasUp, known := o.up[key]
+	o.up[key] = up
+	o.mu.Unlock()
+
+	switch {
+	case up && (!known || !wasUp):
+		o.serversUp.Inc()
+	case !up && known && wasUp:
+		o.serversUp.Dec()
+	}
+}
+
+// OnRingRebuild implements selector.Observer.
+func (o *Observer) OnRingRebuild(points int) {
+	o.ringPoints.Set(float64(points))
+}
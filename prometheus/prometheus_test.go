@@ -0,0 +1,37 @@
+package prometheus
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserverMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	o, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 11211}
+
+	o.OnPick("some key", addr)
+	o.OnPick("some key", addr)
+	assert.Equal(t, float64(2), testutil.ToFloat64(o.picksTotal.WithLabelValues(addr.String())))
+
+	o.OnRingRebuild(320)
+	assert.Equal(t, float64(320), testutil.ToFloat64(o.ringPoints))
+
+	o.OnHealthChange(addr, false, 10*time.Millisecond)
+	assert.Equal(t, float64(0), testutil.ToFloat64(o.serversUp))
+
+	o.OnHealthChange(addr, true, 5*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.serversUp))
+
+	o.OnResolve("memcache.test.com", nil, []net.IP{net.ParseIP("127.0.0.1")})
+	assert.Equal(t, float64(1), testutil.ToFloat64(o.resolveTotal.WithLabelValues("memcache.test.com", "changed")))
+}
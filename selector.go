@@ -2,13 +2,15 @@ package selector
 
 import (
 	"errors"
+	"fmt"
 	"hash/crc32"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/bradfitz/gomemcache/memcache"
-	"go.uber.org/zap"
 )
 
 var (
@@ -16,8 +18,35 @@ var (
 	ErrNoServers = errors.New("memcache: no servers configured or available")
 )
 
+// defaultVNodes is the number of ring points hashed in per configured
+// server when none is given explicitly. This is the usual ketama range
+// (100-200) that keeps the ~1/N key-movement property on add/remove.
+const defaultVNodes = 160
+
 type ServerList struct {
-	addrs []net.Addr
+	mu     sync.RWMutex
+	vnodes int
+
+	addrs []net.Addr  // static (non-hostname) configured servers
+	hosts []*hostPool // hostname-form servers, kept fresh by watchDNS
+
+	ring     []uint32   // sorted hash points on the ring
+	ringAddr []net.Addr // addr for each point, parallel to ring
+
+	logger          Logger
+	observer        Observer
+	resolver        Resolver
+	resolveInterval time.Duration
+	addrPolicy      PolicyTable
+
+	healthEnabled bool
+	healthCfg     HealthCheckConfig
+	healthMu      sync.RWMutex
+	health        map[string]*serverHealth
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
 }
 
 // staticAddr caches the Network() and String() values from any net.Addr.
@@ -25,9 +54,9 @@ type staticAddr struct {
 	ntw, str string
 }
 
-func newAddrFromString(addr string) net.Addr {
+func newAddrFromString(network, addr string) net.Addr {
 	return &staticAddr{
-		ntw: "tcp",
+		ntw: network,
 		str: addr,
 	}
 }
@@ -35,32 +64,99 @@ func newAddrFromString(addr string) net.Addr {
 func (a *staticAddr) Network() string { return a.ntw }
 func (a *staticAddr) String() string  { return a.str }
 
-func (s *ServerList) NewServerList(logger *zap.Logger, servers ...string) *ServerList {
-	naddr := make([]net.Addr, 0, len(servers))
+// resolveAddr validates server, which is either a unix socket path
+// (contains "/") or a "host:port" tcp address, and returns a net.Addr
+// for it.
+func resolveAddr(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		if _, err := net.ResolveUnixAddr("unix", server); err != nil {
+			return nil, fmt.Errorf("can't resolve unix addr %q: %w", server, err)
+		}
+		return newAddrFromString("unix", server), nil
+	}
+
+	if _, err := net.ResolveTCPAddr("tcp", server); err != nil {
+		return nil, fmt.Errorf("can't resolve tcp addr %q: %w", server, err)
+	}
+	return newAddrFromString("tcp", server), nil
+}
+
+// WithVNodes overrides the number of ring points hashed in per
+// configured server (see defaultVNodes). Higher values spread keys more
+// evenly across servers at the cost of a larger ring to search.
+func WithVNodes(n int) Option {
+	return func(s *ServerList) { s.vnodes = n }
+}
+
+// NewServerList resolves servers (tcp "host:port" or unix socket paths)
+// into a ServerList. Hostname-form tcp servers are additionally handed
+// to a background resolver goroutine (see dns.go) that keeps their IP
+// pool current; call Close when done with the list to stop it.
+func NewServerList(logger Logger, servers []string, opts ...Option) (*ServerList, error) {
+	if logger == nil {
+		logger = defaultLogger()
+	}
+	s := &ServerList{
+		vnodes:          defaultVNodes,
+		logger:          logger,
+		resolver:        net.DefaultResolver,
+		resolveInterval: defaultResolveInterval,
+		addrPolicy:      DefaultPolicyTable(),
+		health:          make(map[string]*serverHealth),
+		closeCh:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	addrs := make([]net.Addr, 0, len(servers))
 	for _, server := range servers {
-		if strings.Contains(server, "/") {
-			_, err := net.ResolveUnixAddr("unix", server)
+		host, ok := hostnameOf(server)
+		if !ok {
+			addr, err := resolveAddr(server)
 			if err != nil {
-				logger.Fatal("can't resolve unix addr", zap.Error(err))
+				return nil, fmt.Errorf("can't resolve server addr: %w", err)
 			}
-			naddr = append(naddr, newAddrFromString(server))
-		} else {
-			_, err := net.ResolveTCPAddr("tcp", server)
-			if err != nil {
-				logger.Fatal("can't resolve tcp addr", zap.Error(err))
-			}
-			naddr = append(naddr, newAddrFromString(server))
+			addrs = append(addrs, addr)
+			continue
+		}
+
+		_, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse tcp addr %q: %w", server, err)
+		}
+		hp := &hostPool{host: host, port: port}
+		if err := s.resolveHost(hp); err != nil {
+			return nil, fmt.Errorf("can't resolve host %q: %w", host, err)
 		}
+		s.hosts = append(s.hosts, hp)
 	}
+	s.addrs = addrs
+
+	s.mu.Lock()
+	points := s.rebuildRingLocked()
+	s.mu.Unlock()
 
-	return &ServerList{
-		addrs: naddr,
+	s.notifyRingRebuild(points)
+
+	s.wg.Add(1)
+	go s.watchDNS()
+
+	if s.healthEnabled {
+		s.wg.Add(1)
+		go s.watchHealth()
 	}
+
+	return s, nil
 }
 
 // Each iterates over each server calling the given function
 func (s *ServerList) Each(f func(net.Addr) error) error {
-	for _, a := range s.addrs {
+	s.mu.RLock()
+	addrs := s.allAddrsLocked()
+	s.mu.RUnlock()
+
+	for _, a := range addrs {
 		if err := f(a); nil != err {
 			return err
 		}
@@ -68,6 +164,138 @@ func (s *ServerList) Each(f func(net.Addr) error) error {
 	return nil
 }
 
+// ringPoint is a single hash-ring entry before sorting.
+type ringPoint struct {
+	hash uint32
+	addr net.Addr
+}
+
+// vnodeHashes returns the vnodes ring points for addr.
+func vnodeHashes(addr net.Addr, vnodes int) []ringPoint {
+	points := make([]ringPoint, vnodes)
+	for i := 0; i < vnodes; i++ {
+		label := fmt.Sprintf("%s-%d", addr.String(), i)
+		points[i] = ringPoint{hash: crc32.ChecksumIEEE([]byte(label)), addr: addr}
+	}
+	return points
+}
+
+// RebuildRing replaces the static (non-hostname) server set and
+// recomputes every ring point from scratch, static and hostname-derived
+// alike. Use AddServer/RemoveServer for incremental changes to the
+// static set, which only touch the affected points.
+func (s *ServerList) RebuildRing(addrs []net.Addr) {
+	s.mu.Lock()
+	s.addrs = addrs
+	points := s.rebuildRingLocked()
+	s.mu.Unlock()
+
+	s.notifyRingRebuild(points)
+}
+
+// allAddrsLocked returns every currently configured server address: the
+// static set plus each hostname pool's current resolved addresses,
+// including servers the health checker has marked down.
+// Callers must hold s.mu (for reading or writing).
+func (s *ServerList) allAddrsLocked() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.addrs)+len(s.hosts)*2)
+	addrs = append(addrs, s.addrs...)
+	for _, hp := range s.hosts {
+		addrs = append(addrs, hp.addrs()...)
+	}
+	return addrs
+}
+
+// rebuildRingLocked recomputes every ring point from the current static
+// and hostname-derived addresses and returns the resulting ring size.
+// Callers must hold s.mu for writing.
+func (s *ServerList) rebuildRingLocked() int {
+	if s.vnodes == 0 {
+		s.vnodes = defaultVNodes
+	}
+	addrs := s.allAddrsLocked()
+	points := make([]ringPoint, 0, len(addrs)*s.vnodes)
+	for _, a := range addrs {
+		points = append(points, vnodeHashes(a, s.vnodes)...)
+	}
+	return s.setRingLocked(points)
+}
+
+// setRingLocked sorts points by hash, installs them as the ring, and
+// returns the resulting ring size. Callers must hold s.mu for writing.
+func (s *ServerList) setRingLocked(points []ringPoint) int {
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := make([]uint32, len(points))
+	ringAddr := make([]net.Addr, len(points))
+	for i, p := range points {
+		ring[i] = p.hash
+		ringAddr[i] = p.addr
+	}
+	s.ring = ring
+	s.ringAddr = ringAddr
+	return len(ring)
+}
+
+// AddServer adds server to the ring, hashing in only its own vnodes
+// rather than rebuilding the whole ring.
+func (s *ServerList) AddServer(server string) error {
+	addr, err := resolveAddr(server)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+
+	if s.vnodes == 0 {
+		s.vnodes = defaultVNodes
+	}
+	newPoints := vnodeHashes(addr, s.vnodes)
+	s.addrs = append(s.addrs, addr)
+
+	points := make([]ringPoint, len(s.ring), len(s.ring)+len(newPoints))
+	for i, h := range s.ring {
+		points[i] = ringPoint{hash: h, addr: s.ringAddr[i]}
+	}
+	points = append(points, newPoints...)
+	n := s.setRingLocked(points)
+	s.mu.Unlock()
+
+	s.notifyRingRebuild(n)
+	return nil
+}
+
+// RemoveServer drops server and its vnodes from the ring.
+func (s *ServerList) RemoveServer(server string) error {
+	addr, err := resolveAddr(server)
+	if err != nil {
+		return err
+	}
+	target := addr.String()
+
+	s.mu.Lock()
+
+	addrs := make([]net.Addr, 0, len(s.addrs))
+	for _, a := range s.addrs {
+		if a.String() != target {
+			addrs = append(addrs, a)
+		}
+	}
+	s.addrs = addrs
+
+	points := make([]ringPoint, 0, len(s.ring))
+	for i, h := range s.ring {
+		if s.ringAddr[i].String() != target {
+			points = append(points, ringPoint{hash: h, addr: s.ringAddr[i]})
+		}
+	}
+	n := s.setRingLocked(points)
+	s.mu.Unlock()
+
+	s.notifyRingRebuild(n)
+	return nil
+}
+
 // keyBufPool returns []byte buffers for use by PickServer's call to
 // crc32.ChecksumIEEE to avoid allocations. (but doesn't avoid the
 // copies, which at least are bounded in size and small)
@@ -78,17 +306,76 @@ var keyBufPool = sync.Pool{
 	},
 }
 
+// hashKey hashes key the same way vnode labels are hashed, via a pooled
+// buffer to avoid an allocation per call.
+func hashKey(key string) uint32 {
+	bufp := keyBufPool.Get().(*[]byte)
+	n := copy(*bufp, key)
+	h := crc32.ChecksumIEEE((*bufp)[:n])
+	keyBufPool.Put(bufp)
+	return h
+}
+
+// maxPickHops bounds how many ring points PickServer will walk forward
+// looking for a healthy server before giving up with ErrNoServers.
+const maxPickHops = 32
+
+// PickServer returns the server responsible for key on the consistent
+// hash ring: the first ring point whose hash is >= hash(key), wrapping
+// around to index 0 past the last point. When health checking is
+// enabled (see WithHealthChecking), a down server is skipped in favor
+// of the next live point on the ring, up to maxPickHops hops.
 func (s *ServerList) PickServer(key string) (net.Addr, error) {
-	if len(s.addrs) == 0 {
+	h := hashKey(key)
+
+	s.mu.RLock()
+
+	if len(s.ring) == 0 {
+		s.mu.RUnlock()
 		return nil, ErrNoServers
 	}
-	if len(s.addrs) == 1 {
-		return s.addrs[0], nil
+
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
 	}
-	bufp := keyBufPool.Get().(*[]byte)
-	n := copy(*bufp, key)
-	cs := crc32.ChecksumIEEE((*bufp)[:n])
-	keyBufPool.Put(bufp)
 
-	return s.addrs[cs%uint32(len(s.addrs))], nil
+	if !s.healthEnabled {
+		addr := s.ringAddr[i]
+		s.mu.RUnlock()
+		s.notifyPick(key, addr)
+		return addr, nil
+	}
+
+	hops := len(s.ring)
+	if hops > maxPickHops {
+		hops = maxPickHops
+	}
+	for n := 0; n < hops; n++ {
+		addr := s.ringAddr[(i+n)%len(s.ring)]
+		if s.isHealthyLocked(addr) {
+			s.mu.RUnlock()
+			s.notifyPick(key, addr)
+			return addr, nil
+		}
+	}
+	s.mu.RUnlock()
+	return nil, ErrNoServers
+}
+
+// notifyPick reports a successful pick to the observer, if any. Callers
+// must NOT hold s.mu: observers may call back into ServerList (e.g. from
+// AddServer), and re-entering the lock from the same goroutine deadlocks.
+func (s *ServerList) notifyPick(key string, addr net.Addr) {
+	if s.observer != nil {
+		s.observer.OnPick(key, addr)
+	}
+}
+
+// notifyRingRebuild reports a ring rebuild to the observer, if any.
+// Callers must NOT hold s.mu (see notifyPick).
+func (s *ServerList) notifyRingRebuild(points int) {
+	if s.observer != nil {
+		s.observer.OnRingRebuild(points)
+	}
 }
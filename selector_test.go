@@ -9,9 +9,13 @@ import (
 	"github.com/foxcpp/go-mockdns"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"io"
 	"net"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -31,7 +35,11 @@ func BenchmarkPickServer_Single(b *testing.B) {
 
 func benchPickServer(b *testing.B, servers ...string) {
 	b.ReportAllocs()
-	ss := NewServerList(zap.NewNop(), servers...)
+	ss, err := NewServerList(NewZapLogger(zap.NewNop()), servers)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ss.Close()
 	for i := 0; i < b.N; i++ {
 		if _, err := ss.PickServer("some key"); err != nil {
 			b.Fatal(err)
@@ -39,7 +47,9 @@ func benchPickServer(b *testing.B, servers ...string) {
 	}
 }
 
-// TestDnsIpChangedCustomServerSelector тест кастомного сервер селектора
+// TestDnsIpChangedCustomServerSelector тест кастомного сервер селектора:
+// теперь ServerList сам в фоне следит за DNS через внедрённый Resolver,
+// вместо того чтобы полагаться на переподключение gomemcache при ошибке.
 func TestDnsIpChangedCustomServerSelector(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -53,20 +63,16 @@ func TestDnsIpChangedCustomServerSelector(t *testing.T) {
 	fakeServer2 := createFakeMemcache(ctx, "tcp", "[::1]:"+port, logger)
 	defer fakeServer2.Close()
 
-	// патчим дефолтный dns resolver чтобы по тестовому хосту резолвился сначала ТОЛЬКО сервер №1
-	srv, err := mockdns.NewServerWithLogger(map[string]mockdns.Zone{
-		testHost + ".": {A: []string{"127.0.0.1"}},
-	}, dnsLogger, false)
+	resolver := newFakeResolver()
+	resolver.set(testHost, net.IPAddr{IP: net.ParseIP("127.0.0.1")})
+
+	// инитим мемкеш с коротким интервалом резолва, чтобы не ждать в тесте defaultResolveInterval
+	ss, err := NewServerList(NewZapLogger(logger), []string{testHost + ":" + port},
+		WithResolver(resolver), WithResolveInterval(5*time.Millisecond))
 	if err != nil {
-		logger.Fatal("can't create dns", zap.Error(err))
+		t.Fatal(err)
 	}
-	defer srv.Close()
-	srv.PatchNet(net.DefaultResolver)
-	// Important if net.DefaultResolver is modified.
-	defer mockdns.UnpatchNet(net.DefaultResolver)
-
-	// инитим мемкеш
-	ss := NewServerList(logger, testHost+":"+port)
+	defer ss.Close()
 	mc := memcache.NewFromSelector(ss)
 
 	// пингуем, ожидаем что ошибок нет
@@ -75,23 +81,37 @@ func TestDnsIpChangedCustomServerSelector(t *testing.T) {
 	// закрываем фейковый сервер чтобы убедить что запрос на него не придет
 	fakeServer1.Close()
 
-	//переписываем dns чтобы тот же хост смотрел уже на сервер №2
-	srv, err = mockdns.NewServerWithLogger(map[string]mockdns.Zone{
-		testHost + ".": {AAAA: []string{"::1"}},
-	}, dnsLogger, false)
-	if err != nil {
-		logger.Fatal("can't update dns", zap.Error(err))
-	}
-	defer srv.Close()
+	// переписываем dns чтобы тот же хост смотрел уже на сервер №2
+	resolver.set(testHost, net.IPAddr{IP: net.ParseIP("::1")})
 
-	srv.PatchNet(net.DefaultResolver)
+	// как только фоновый резолвер подхватит смену IP, пинги снова пойдут без ошибок
+	assert.Eventually(t, func() bool {
+		return mc.Ping() == nil
+	}, time.Second, 5*time.Millisecond)
+}
 
-	// первый запрос после смены dns отваливается по timeout ТК либа пишет себе локальный кэш ip -> хост
-	// но после фейла кэш потрется и на следующий запрос мы получим новый ip для коннекта
-	assert.ErrorContains(t, mc.Ping(), "i/o timeout")
+// fakeResolver is a Resolver whose answers are set directly by tests,
+// replacing mockdns patching of net.DefaultResolver for selector-owned
+// DNS tracking.
+type fakeResolver struct {
+	mu  sync.Mutex
+	ips map[string][]net.IPAddr
+}
 
-	// пингуем, ожидаем что ошибок нет, хотя IP поменялся
-	assert.NoError(t, mc.Ping())
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{ips: make(map[string][]net.IPAddr)}
+}
+
+func (f *fakeResolver) set(host string, addrs ...net.IPAddr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ips[host] = addrs
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ips[host], nil
 }
 
 // TestDnsIpChangedDefaultServerSelector тест сервер селектора по умолчанию, убеждаемся что он не может менять IP при смене DNS
@@ -149,6 +169,198 @@ func TestDnsIpChangedDefaultServerSelector(t *testing.T) {
 	assert.ErrorContains(t, mc.Ping(), "connection refused")
 }
 
+// TestPickServerStableAcrossRepeats checks that PickServer is a pure
+// function of the ring and key: repeated calls with the same key and an
+// unchanged server set always land on the same server.
+func TestPickServerStableAcrossRepeats(t *testing.T) {
+	ss, err := NewServerList(NewZapLogger(zap.NewNop()), []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	first, err := ss.PickServer("stable-key")
+	assert.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		addr, err := ss.PickServer("stable-key")
+		assert.NoError(t, err)
+		assert.Equal(t, first.String(), addr.String())
+	}
+}
+
+// TestWithVNodesControlsRingDensity checks that WithVNodes actually
+// reaches the ring: N servers at V vnodes each must produce exactly N*V
+// ring points.
+func TestWithVNodesControlsRingDensity(t *testing.T) {
+	servers := []string{"127.0.0.1:1", "127.0.0.1:2"}
+
+	ss, err := NewServerList(NewZapLogger(zap.NewNop()), servers, WithVNodes(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	assert.Len(t, ss.ring, 8*len(servers))
+}
+
+// TestPickServerWrapsToFirstRingPoint checks the wraparound case called
+// out in PickServer's doc comment: a key hashing past every ring point
+// must land on ring index 0, not ErrNoServers.
+func TestPickServerWrapsToFirstRingPoint(t *testing.T) {
+	ss, err := NewServerList(NewZapLogger(zap.NewNop()), []string{"127.0.0.1:1", "127.0.0.1:2"}, WithVNodes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	if !assert.Len(t, ss.ring, 2) {
+		t.FailNow()
+	}
+	maxHash := ss.ring[len(ss.ring)-1]
+	firstAddr := ss.ringAddr[0].String()
+
+	var wrapKey string
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("wrap-%d", i)
+		if hashKey(key) > maxHash {
+			wrapKey = key
+			break
+		}
+	}
+
+	addr, err := ss.PickServer(wrapKey)
+	assert.NoError(t, err)
+	assert.Equal(t, firstAddr, addr.String())
+}
+
+// TestAddRemoveServerRemapsMinorityOfKeys locks in the actual motivating
+// property from the request that replaced modulo sharding with a ring:
+// growing/shrinking the server set should only remap a small fraction of
+// keys, not reshuffle everything the way `hash(key) % N` would.
+func TestAddRemoveServerRemapsMinorityOfKeys(t *testing.T) {
+	initial := []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"}
+	ss, err := NewServerList(NewZapLogger(zap.NewNop()), initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	const numKeys = 2000
+	before := make(map[string]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		addr, err := ss.PickServer(key)
+		assert.NoError(t, err)
+		before[key] = addr.String()
+	}
+
+	assert.NoError(t, ss.AddServer("127.0.0.1:4"))
+
+	moved := 0
+	for key, prevAddr := range before {
+		addr, err := ss.PickServer(key)
+		assert.NoError(t, err)
+		if addr.String() != prevAddr {
+			moved++
+		}
+	}
+
+	// Consistent hashing's headline property: adding a 4th server to 3
+	// should remap roughly 1/4 of keys, nowhere near the full reshuffle
+	// modulo sharding would cause. Leave generous slack around that.
+	frac := float64(moved) / float64(numKeys)
+	assert.Greater(t, frac, 0.05)
+	assert.Less(t, frac, 0.45)
+
+	// Removing it again should undo the remap: every key returns to the
+	// server it was on before AddServer.
+	assert.NoError(t, ss.RemoveServer("127.0.0.1:4"))
+	for key, prevAddr := range before {
+		addr, err := ss.PickServer(key)
+		assert.NoError(t, err)
+		assert.Equal(t, prevAddr, addr.String())
+	}
+}
+
+// TestHealthCheckFailover проверяет, что после FailureThreshold неудачных
+// проб PickServer перестаёт отдавать упавший сервер и всегда попадает на
+// живой, несмотря на то что часть ключей напрямую хэшируется на упавший.
+func TestHealthCheckFailover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := zap.NewNop()
+
+	healthyServer := createFakeMemcache(ctx, "tcp", "127.0.0.1:0", logger)
+	defer healthyServer.Close()
+
+	downServer := createFakeMemcache(ctx, "tcp", "127.0.0.1:0", logger)
+	downAddr := downServer.Addr().String()
+	downServer.Close() // закрыт сразу, так что каждая проба будет фейлиться
+
+	ss, err := NewServerList(NewZapLogger(logger), []string{healthyServer.Addr().String(), downAddr},
+		WithHealthChecking(HealthCheckConfig{
+			Interval:         5 * time.Millisecond,
+			FailureThreshold: 2,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	assert.Eventually(t, func() bool {
+		healthy, _, _ := ss.ServerStatus(newAddrFromString("tcp", downAddr))
+		return !healthy
+	}, time.Second, 5*time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		addr, err := ss.PickServer(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, healthyServer.Addr().String(), addr.String())
+	}
+}
+
+// TestHealthCheckFailoverUnixSocket guards against probe() silently
+// failing every unix-socket backend: staticAddr must report its real
+// network ("unix"), not hardcode "tcp", or DialTimeout dials the wrong
+// network and every probe round fails.
+func TestHealthCheckFailoverUnixSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := zap.NewNop()
+
+	healthySock := filepath.Join(t.TempDir(), "healthy.sock")
+	healthyServer := createFakeMemcache(ctx, "unix", healthySock, logger)
+	defer healthyServer.Close()
+
+	downSock := filepath.Join(t.TempDir(), "down.sock")
+	downServer := createFakeMemcache(ctx, "unix", downSock, logger)
+	downServer.Close() // закрыт сразу, так что каждая проба будет фейлиться
+
+	ss, err := NewServerList(NewZapLogger(logger), []string{healthySock, downSock},
+		WithHealthChecking(HealthCheckConfig{
+			Interval:         5 * time.Millisecond,
+			FailureThreshold: 2,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+
+	assert.Eventually(t, func() bool {
+		healthy, _, _ := ss.ServerStatus(newAddrFromString("unix", downSock))
+		return !healthy
+	}, time.Second, 5*time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		addr, err := ss.PickServer(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, healthySock, addr.String())
+	}
+}
+
 func createFakeMemcache(ctx context.Context, network, addr string, logger *zap.Logger) net.Listener {
 	var lc net.ListenConfig
 	fakeServer, err := lc.Listen(ctx, network, addr)
@@ -162,6 +374,12 @@ func createFakeMemcache(ctx context.Context, network, addr string, logger *zap.L
 					rw := bufio.NewReadWriter(bufio.NewReader(connect), bufio.NewWriter(connect))
 					bar, err := rw.ReadSlice('\n')
 					if err != nil {
+						if err == io.EOF {
+							// connect-only probe (e.g. probe()'s unix-socket
+							// health check, which never writes anything): nothing
+							// to reply to.
+							return
+						}
 						logger.Fatal("can't read bytes", zap.Error(err))
 					}
 